@@ -2,6 +2,9 @@ package controller
 
 import (
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
@@ -9,6 +12,7 @@ import (
 	errors "github.com/GoogleCloudPlatform/kubernetes/pkg/api/errors"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/record"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
 
 	buildapi "github.com/openshift/origin/pkg/build/api"
@@ -17,13 +21,64 @@ import (
 	imageapi "github.com/openshift/origin/pkg/image/api"
 )
 
+// BuildPodCreationRetryParams controls the exponential backoff used by BuildController
+// when a build pod fails to create for a retryable reason (apiserver conflicts, quota
+// races, and throttled requests). It can be tuned per-cluster to match expected apiserver
+// load.
+type BuildPodCreationRetryParams struct {
+	// Steps is the maximum number of creation attempts before pod creation is given up
+	// and a failedCreate event is recorded; the build itself is left in BuildStatusNew.
+	Steps int
+	// Interval is the delay before the first retry. Each subsequent retry multiplies
+	// the previous delay by Factor.
+	Interval time.Duration
+	// Factor is the multiplier applied to Interval after each attempt.
+	Factor float64
+	// Jitter is the fraction of randomness (0.0-1.0) added to each delay to avoid
+	// retry storms across many builds at once.
+	Jitter float64
+}
+
+// DefaultBuildPodCreationRetryParams returns the backoff BuildController uses when no
+// explicit BuildPodCreationRetryParams have been set.
+func DefaultBuildPodCreationRetryParams() BuildPodCreationRetryParams {
+	return BuildPodCreationRetryParams{
+		Steps:    5,
+		Interval: 500 * time.Millisecond,
+		Factor:   2.0,
+		Jitter:   0.2,
+	}
+}
+
 // BuildController watches build resources and manages their state
 type BuildController struct {
 	BuildUpdater      buildclient.BuildUpdater
+	BuildGetter       buildGetter
 	PodManager        podManager
 	BuildStrategy     BuildStrategy
 	ImageStreamClient imageStreamClient
 	Recorder          record.EventRecorder
+
+	// BuildPodCreationRetryParams controls the backoff used when retrying pod creation
+	// after a transient error. If Steps is zero, DefaultBuildPodCreationRetryParams is used.
+	BuildPodCreationRetryParams BuildPodCreationRetryParams
+
+	// BuildTimeout is the default wall-clock deadline given to a build once it starts
+	// running, used when the build does not set Parameters.CompletionDeadlineSeconds.
+	// Zero means builds have no default deadline.
+	BuildTimeout time.Duration
+
+	// BuildStore is consulted by StartQueueSweep to find builds held in BuildStatusNew
+	// by BuildQueue so they can be re-evaluated once another build completes.
+	BuildStore cache.Store
+	// BuildQueue enforces the concurrency policy of a build's BuildConfig before it is
+	// allowed to leave BuildStatusNew. If nil, builds are never queued.
+	BuildQueue BuildQueue
+
+	// PodPlacementPolicy customizes the pod produced by BuildStrategy before it is
+	// submitted to the API server, for example to size or place it according to labels
+	// on the build. If nil, the strategy's pod spec is used unmodified.
+	PodPlacementPolicy BuildPodPlacementPolicy
 }
 
 // BuildStrategy knows how to create a pod spec for a pod which can execute a build.
@@ -33,14 +88,211 @@ type BuildStrategy interface {
 
 type podManager interface {
 	CreatePod(namespace string, pod *kapi.Pod) (*kapi.Pod, error)
-	DeletePod(namespace string, pod *kapi.Pod) error
+	DeletePod(namespace string, pod *kapi.Pod, options *kapi.DeleteOptions) error
 	GetPod(namespace, name string) (*kapi.Pod, error)
+	ListPods(namespace string, selector labels.Selector) (*kapi.PodList, error)
 }
 
 type imageStreamClient interface {
 	GetImageStream(namespace, name string) (*imageapi.ImageStream, error)
 }
 
+type buildGetter interface {
+	Get(namespace, name string) (*buildapi.Build, error)
+}
+
+// BuildConcurrencyPolicy controls how many builds belonging to the same BuildConfig may
+// be active (Pending or Running) at the same time.
+type BuildConcurrencyPolicy string
+
+const (
+	// BuildConcurrencyPolicySerial allows only one active build per BuildConfig; other
+	// builds are left in BuildStatusNew until it completes.
+	BuildConcurrencyPolicySerial BuildConcurrencyPolicy = "Serial"
+	// BuildConcurrencyPolicySerialLatestOnly behaves like Serial, but additionally
+	// cancels any other builds still queued in BuildStatusNew when a newer build for
+	// the same BuildConfig is ready to proceed.
+	BuildConcurrencyPolicySerialLatestOnly BuildConcurrencyPolicy = "SerialLatestOnly"
+	// BuildConcurrencyPolicyParallel allows up to a configured maximum number of active
+	// builds per BuildConfig.
+	BuildConcurrencyPolicyParallel BuildConcurrencyPolicy = "Parallel"
+)
+
+// BuildQueue decides whether a build that is ready to leave BuildStatusNew may proceed
+// now, or must wait for other builds belonging to the same BuildConfig.
+type BuildQueue interface {
+	// Admit returns true if build may proceed to BuildStatusPending. If it returns
+	// false, build is left in BuildStatusNew and should be reconsidered later, for
+	// example by BuildController.StartQueueSweep.
+	Admit(build *buildapi.Build) (bool, error)
+}
+
+// BuildConcurrencyPolicyFunc returns the concurrency policy that applies to the named
+// BuildConfig in namespace, and the maximum number of active builds permitted when the
+// policy is BuildConcurrencyPolicyParallel.
+type BuildConcurrencyPolicyFunc func(namespace, buildConfigName string) (BuildConcurrencyPolicy, int)
+
+// DefaultBuildQueue is a BuildQueue that enforces concurrency policy by scanning
+// BuildStore for the other builds that belong to the same BuildConfig, identified by
+// the buildapi.BuildConfigLabel label.
+type DefaultBuildQueue struct {
+	BuildStore cache.Store
+	Policy     BuildConcurrencyPolicyFunc
+	// Cancel supersedes an older queued build in favor of a newer one under
+	// BuildConcurrencyPolicySerialLatestOnly. Marking the build cancelled and saving it
+	// is enough; BuildPodController tears down any pod on its next reconcile.
+	Cancel func(build *buildapi.Build) error
+}
+
+func (q *DefaultBuildQueue) Admit(build *buildapi.Build) (bool, error) {
+	buildConfigName := build.Labels[buildapi.BuildConfigLabel]
+	if len(buildConfigName) == 0 {
+		return true, nil
+	}
+
+	policy, maxParallel := BuildConcurrencyPolicyParallel, 0
+	if q.Policy != nil {
+		policy, maxParallel = q.Policy(build.Namespace, buildConfigName)
+	}
+
+	active, queued := q.buildConfigBuilds(build.Namespace, buildConfigName)
+
+	switch policy {
+	case BuildConcurrencyPolicySerial:
+		return active == 0, nil
+
+	case BuildConcurrencyPolicySerialLatestOnly:
+		if active > 0 {
+			return false, nil
+		}
+		if newest := latestBuild(queued); newest != nil && newest.Name != build.Name {
+			// a newer build for this BuildConfig is queued behind us; let it supersede
+			// this one instead of proceeding out of order
+			return false, nil
+		}
+		for _, older := range queued {
+			if older.Name == build.Name {
+				continue
+			}
+			if q.Cancel == nil {
+				continue
+			}
+			if err := q.Cancel(older); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case BuildConcurrencyPolicyParallel:
+		if maxParallel <= 0 {
+			return true, nil
+		}
+		return active < maxParallel, nil
+
+	default:
+		return true, nil
+	}
+}
+
+// buildConfigBuilds returns the number of active (Pending or Running) builds for the
+// BuildConfig named buildConfigName in namespace, and the builds still queued in
+// BuildStatusNew.
+func (q *DefaultBuildQueue) buildConfigBuilds(namespace, buildConfigName string) (active int, queued []*buildapi.Build) {
+	for _, obj := range q.BuildStore.List() {
+		other, ok := obj.(*buildapi.Build)
+		if !ok || other.Namespace != namespace || other.Labels[buildapi.BuildConfigLabel] != buildConfigName {
+			continue
+		}
+		switch other.Status {
+		case buildapi.BuildStatusPending, buildapi.BuildStatusRunning:
+			active++
+		case buildapi.BuildStatusNew:
+			queued = append(queued, other)
+		}
+	}
+	return active, queued
+}
+
+// latestBuild returns the most recently created build in builds, or nil if builds is empty.
+func latestBuild(builds []*buildapi.Build) *buildapi.Build {
+	var latest *buildapi.Build
+	for _, b := range builds {
+		if latest == nil || b.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = b
+		}
+	}
+	return latest
+}
+
+const (
+	// BuildSizeLabel selects a named build class from BuildDefaults.Sizes, controlling
+	// the resource requests/limits given to a build pod (e.g. "large" -> 4 CPU / 8Gi).
+	BuildSizeLabel = "build.openshift.io/size"
+	// BuildArchLabel selects a named build class from BuildDefaults.Architectures,
+	// controlling where a build pod is placed (e.g. "arm64" -> an arm64 node selector).
+	BuildArchLabel = "build.openshift.io/arch"
+)
+
+// BuildClass describes the resource sizing and placement that DefaultBuildPodPlacementPolicy
+// applies to a build pod matching a given BuildSizeLabel or BuildArchLabel value.
+type BuildClass struct {
+	Resources    kapi.ResourceRequirements
+	NodeSelector map[string]string
+	Tolerations  []kapi.Toleration
+}
+
+// BuildDefaults declares the cluster-wide "build classes" available to
+// DefaultBuildPodPlacementPolicy, so administrators can define sizing and placement once
+// instead of configuring every BuildStrategy individually.
+type BuildDefaults struct {
+	Sizes         map[string]BuildClass
+	Architectures map[string]BuildClass
+}
+
+// BuildPodPlacementPolicy customizes the pod produced by a BuildStrategy before it is
+// submitted to the API server, based on labels carried by the Build (propagated from its
+// BuildConfig), such as node sizing and placement.
+type BuildPodPlacementPolicy interface {
+	// Apply mutates pod in place to reflect build's placement requirements.
+	Apply(build *buildapi.Build, pod *kapi.Pod)
+}
+
+// DefaultBuildPodPlacementPolicy is a BuildPodPlacementPolicy that applies the
+// BuildClass named by a build's BuildSizeLabel and BuildArchLabel labels.
+type DefaultBuildPodPlacementPolicy struct {
+	Defaults BuildDefaults
+}
+
+func (p *DefaultBuildPodPlacementPolicy) Apply(build *buildapi.Build, pod *kapi.Pod) {
+	if class, ok := p.Defaults.Sizes[build.Labels[BuildSizeLabel]]; ok {
+		applyBuildClass(pod, class)
+	}
+	if class, ok := p.Defaults.Architectures[build.Labels[BuildArchLabel]]; ok {
+		applyBuildClass(pod, class)
+	}
+}
+
+// applyBuildClass merges class into pod's node selector and tolerations, and sets
+// resource requirements on every container in the pod.
+func applyBuildClass(pod *kapi.Pod, class BuildClass) {
+	if len(class.NodeSelector) > 0 {
+		if pod.Spec.NodeSelector == nil {
+			pod.Spec.NodeSelector = map[string]string{}
+		}
+		for k, v := range class.NodeSelector {
+			pod.Spec.NodeSelector[k] = v
+		}
+	}
+	if len(class.Tolerations) > 0 {
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, class.Tolerations...)
+	}
+	if class.Resources.Limits != nil || class.Resources.Requests != nil {
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].Resources = class.Resources
+		}
+	}
+}
+
 func (bc *BuildController) HandleBuild(build *buildapi.Build) error {
 	glog.V(4).Infof("Handling build %s", build.Name)
 
@@ -49,9 +301,22 @@ func (bc *BuildController) HandleBuild(build *buildapi.Build) error {
 		return nil
 	}
 
-	if err := bc.nextBuildStatus(build); err != nil {
+	if bc.BuildQueue != nil {
+		admitted, err := bc.BuildQueue.Admit(build)
+		if err != nil {
+			return fmt.Errorf("unable to evaluate concurrency policy for build %s/%s: %v", build.Namespace, build.Name, err)
+		}
+		if !admitted {
+			glog.V(4).Infof("Build %s/%s is waiting for its BuildConfig's concurrency policy", build.Namespace, build.Name)
+			return nil
+		}
+	}
+
+	updated, err := bc.nextBuildStatus(build)
+	if err != nil {
 		return fmt.Errorf("Build failed with error %s/%s: %v", build.Namespace, build.Name, err)
 	}
+	build = updated
 
 	if err := bc.BuildUpdater.Update(build.Namespace, build); err != nil {
 		// This is not a retryable error because the build has been created.  The worst case
@@ -64,71 +329,248 @@ func (bc *BuildController) HandleBuild(build *buildapi.Build) error {
 }
 
 // nextBuildStatus updates build with any appropriate changes, or returns an error if
-// the change cannot occur. When returning nil, be sure to set build.Status and optionally
-// build.Message.
-func (bc *BuildController) nextBuildStatus(build *buildapi.Build) error {
+// the change cannot occur. It returns the build object that should be persisted by the
+// caller: ordinarily build itself, but if a transient pod creation error caused a build
+// to be re-fetched mid-retry, the re-fetched object, so the caller does not persist a
+// stale copy over the one actually used to create the pod.
+func (bc *BuildController) nextBuildStatus(build *buildapi.Build) (*buildapi.Build, error) {
 	// If a cancelling event was triggered for the build, update build status.
 	if build.Cancelled {
 		glog.V(4).Infof("Cancelling build %s.", build.Name)
 		build.Status = buildapi.BuildStatusCancelled
-		return nil
+		return build, nil
 	}
 
-	// lookup the destination from the referenced image repository
-	spec := build.Parameters.Output.DockerImageReference
-	if ref := build.Parameters.Output.To; ref != nil {
-		// TODO: security, ensure that the reference image stream is actually visible
-		namespace := ref.Namespace
-		if len(namespace) == 0 {
-			namespace = build.Namespace
-		}
+	if err := bc.prepareBuildForPod(build); err != nil {
+		return nil, err
+	}
 
-		repo, err := bc.ImageStreamClient.GetImageStream(namespace, ref.Name)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				return fmt.Errorf("the referenced output image stream %s/%s does not exist", namespace, ref.Name)
-			}
-			return fmt.Errorf("the referenced output image stream %s/%s could not be found by build %s/%s: %v", namespace, ref.Name, build.Namespace, build.Name, err)
-		}
-		if len(repo.Status.DockerImageRepository) == 0 {
-			return fmt.Errorf("the image stream %s/%s cannot be used as the output for build %s/%s because the integrated Docker registry is not configured, or the user forgot to set a valid external registry", namespace, ref.Name, build.Namespace, build.Name)
-		}
-		if len(build.Parameters.Output.Tag) == 0 {
-			spec = repo.Status.DockerImageRepository
-		} else {
-			spec = fmt.Sprintf("%s:%s", repo.Status.DockerImageRepository, build.Parameters.Output.Tag)
-		}
+	pod, current, err := bc.createBuildPodWithRetries(build)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.V(4).Infof("Created pod for build: %#v", pod)
+	return current, nil
+}
+
+// prepareBuildForPod resolves build's output spec, marks it BuildStatusPending, and
+// fills in its default completion deadline, mutating build in place. It is applied both
+// to the build HandleBuild was originally given and, on retry, to any copy re-fetched by
+// refreshBuild, so both reflect the same expected state before a pod is created.
+func (bc *BuildController) prepareBuildForPod(build *buildapi.Build) error {
+	spec, err := bc.resolveOutputSpec(build)
+	if err != nil {
+		return err
 	}
 
 	// set the expected build parameters, which will be saved if no error occurs
 	build.Status = buildapi.BuildStatusPending
 	// override DockerImageReference in the strategy for the copy we send to the server
 	build.Parameters.Output.DockerImageReference = spec
+	if build.Parameters.CompletionDeadlineSeconds == nil && bc.BuildTimeout > 0 {
+		seconds := int64(bc.BuildTimeout / time.Second)
+		build.Parameters.CompletionDeadlineSeconds = &seconds
+	}
+	return nil
+}
 
-	copy, err := kapi.Scheme.Copy(build)
-	if err != nil {
-		return fmt.Errorf("unable to copy build: %v", err)
+// resolveOutputSpec computes the DockerImageReference a build's output should be pushed
+// to, resolving build.Parameters.Output.To against the referenced image stream if one
+// is set.
+func (bc *BuildController) resolveOutputSpec(build *buildapi.Build) (string, error) {
+	spec := build.Parameters.Output.DockerImageReference
+	ref := build.Parameters.Output.To
+	if ref == nil {
+		return spec, nil
+	}
+
+	// TODO: security, ensure that the reference image stream is actually visible
+	namespace := ref.Namespace
+	if len(namespace) == 0 {
+		namespace = build.Namespace
 	}
-	buildCopy := copy.(*buildapi.Build)
 
-	// invoke the strategy to get a build pod
-	podSpec, err := bc.BuildStrategy.CreateBuildPod(buildCopy)
+	repo, err := bc.ImageStreamClient.GetImageStream(namespace, ref.Name)
 	if err != nil {
-		return fmt.Errorf("the strategy failed to create a build pod for %s/%s: %v", build.Namespace, build.Name, err)
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("the referenced output image stream %s/%s does not exist", namespace, ref.Name)
+		}
+		return "", fmt.Errorf("the referenced output image stream %s/%s could not be found by build %s/%s: %v", namespace, ref.Name, build.Namespace, build.Name, err)
+	}
+	if len(repo.Status.DockerImageRepository) == 0 {
+		return "", fmt.Errorf("the image stream %s/%s cannot be used as the output for build %s/%s because the integrated Docker registry is not configured, or the user forgot to set a valid external registry", namespace, ref.Name, build.Namespace, build.Name)
 	}
+	if len(build.Parameters.Output.Tag) == 0 {
+		return repo.Status.DockerImageRepository, nil
+	}
+	return fmt.Sprintf("%s:%s", repo.Status.DockerImageRepository, build.Parameters.Output.Tag), nil
+}
+
+// createBuildPodWithRetries invokes the build strategy and creates the resulting pod,
+// retrying with exponential backoff and jitter when CreatePod fails for a retryable
+// reason (apiserver conflicts, server timeouts, or throttling). Before each retry the
+// build is re-fetched and its output spec re-resolved so the regenerated pod spec
+// reflects the latest state. Terminal errors (e.g. quota denied, invalid spec) are not
+// retried and result in a failedCreate event. It returns, alongside the created pod, the
+// build object actually used to create it, which the caller must persist instead of its
+// original, possibly stale, build.
+func (bc *BuildController) createBuildPodWithRetries(build *buildapi.Build) (*kapi.Pod, *buildapi.Build, error) {
+	params := bc.BuildPodCreationRetryParams
+	if params.Steps <= 0 {
+		params = DefaultBuildPodCreationRetryParams()
+	}
+
+	current := build
+	interval := params.Interval
+	var lastErr error
+	for step := 0; step < params.Steps; step++ {
+		if step > 0 {
+			time.Sleep(jitter(interval, params.Jitter))
+			interval = time.Duration(float64(interval) * params.Factor)
+
+			refreshed, err := bc.refreshBuild(current)
+			if err != nil {
+				glog.V(4).Infof("Unable to refresh build %s/%s before retrying pod creation, reusing previous copy: %v", build.Namespace, build.Name, err)
+			} else if err := bc.prepareBuildForPod(refreshed); err != nil {
+				glog.V(4).Infof("Unable to prepare refreshed build %s/%s before retrying pod creation, reusing previous copy: %v", build.Namespace, build.Name, err)
+			} else {
+				current = refreshed
+			}
+		}
+
+		copy, err := kapi.Scheme.Copy(current)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to copy build: %v", err)
+		}
+		buildCopy := copy.(*buildapi.Build)
+
+		podSpec, err := bc.BuildStrategy.CreateBuildPod(buildCopy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("the strategy failed to create a build pod for %s/%s: %v", build.Namespace, build.Name, err)
+		}
+
+		if bc.PodPlacementPolicy != nil {
+			bc.PodPlacementPolicy.Apply(buildCopy, podSpec)
+		}
 
-	if _, err := bc.PodManager.CreatePod(build.Namespace, podSpec); err != nil {
+		pod, err := bc.PodManager.CreatePod(build.Namespace, podSpec)
+		if err == nil {
+			return pod, current, nil
+		}
 		if errors.IsAlreadyExists(err) {
 			glog.V(4).Infof("Build pod already existed: %#v", podSpec)
-			return nil
+			return nil, current, nil
+		}
+		lastErr = err
+		if !isRetryableCreatePodError(err) {
+			break
 		}
-		// log an event if the pod is not created (most likely due to quota denial)
-		bc.Recorder.Eventf(build, "failedCreate", "Error creating: %v", err)
-		return fmt.Errorf("failed to create pod for build %s/%s: %v", build.Namespace, build.Name, err)
+		glog.V(4).Infof("Retrying pod creation for build %s/%s after transient error: %v", build.Namespace, build.Name, err)
 	}
 
-	glog.V(4).Infof("Created pod for build: %#v", podSpec)
-	return nil
+	// log an event if the pod is not created (most likely due to quota denial, or a
+	// retryable error that exhausted its retries)
+	bc.Recorder.Eventf(build, "failedCreate", "Error creating: %v", lastErr)
+	return nil, nil, fmt.Errorf("failed to create pod for build %s/%s: %v", build.Namespace, build.Name, lastErr)
+}
+
+// refreshBuild re-fetches build, returning a build ready to be passed to
+// prepareBuildForPod and then BuildStrategy.CreateBuildPod again. If no BuildGetter is
+// configured, the original build is reused.
+func (bc *BuildController) refreshBuild(build *buildapi.Build) (*buildapi.Build, error) {
+	if bc.BuildGetter == nil {
+		return build, nil
+	}
+	return bc.BuildGetter.Get(build.Namespace, build.Name)
+}
+
+// isRetryableCreatePodError returns true if err represents a transient apiserver
+// condition (conflict, server timeout, or throttling) worth retrying pod creation for,
+// as opposed to a terminal error like quota denial or an invalid pod spec.
+func isRetryableCreatePodError(err error) bool {
+	return errors.IsConflict(err) || errors.IsServerTimeout(err) || errors.IsTooManyRequests(err)
+}
+
+// jitter returns interval adjusted by a random +/- fraction of itself, bounded by
+// factor (0.0 means no jitter).
+func jitter(interval time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return interval
+	}
+	delta := factor * (rand.Float64()*2 - 1)
+	return time.Duration(float64(interval) * (1 + delta))
+}
+
+// StartQueueSweep periodically re-evaluates every build still in BuildStatusNew against
+// BuildQueue, so a build held back by its BuildConfig's concurrency policy is retried
+// once another build from the same BuildConfig completes, rather than waiting for an
+// unrelated watch event. It returns immediately; close stopCh to stop the sweep.
+func (bc *BuildController) StartQueueSweep(period time.Duration, stopCh <-chan struct{}) {
+	if bc.BuildQueue == nil || bc.BuildStore == nil {
+		return
+	}
+	go util.Until(bc.sweepQueuedBuilds, period, stopCh)
+}
+
+func (bc *BuildController) sweepQueuedBuilds() {
+	for _, obj := range bc.BuildStore.List() {
+		cached, ok := obj.(*buildapi.Build)
+		if !ok || cached.Status != buildapi.BuildStatusNew {
+			continue
+		}
+
+		// cached is a pointer straight out of BuildStore; HandleBuild mutates the build
+		// it's given (prepareBuildForPod, status transitions) and the normal watch path
+		// may be doing the same to this object concurrently, so hand HandleBuild a copy
+		// rather than the shared cache entry, as createBuildPodWithRetries already does.
+		copied, err := kapi.Scheme.Copy(cached)
+		if err != nil {
+			glog.V(2).Infof("Unable to copy queued build %s/%s for re-evaluation: %v", cached.Namespace, cached.Name, err)
+			continue
+		}
+		build := copied.(*buildapi.Build)
+		if err := bc.HandleBuild(build); err != nil {
+			glog.V(2).Infof("Error re-evaluating queued build %s/%s: %v", build.Namespace, build.Name, err)
+		}
+	}
+}
+
+// buildTimeoutWarningWindow is how long before a build's deadline expires that
+// BuildPodController emits a warning event, so `oc describe build` shows the pending
+// termination before it actually happens.
+const buildTimeoutWarningWindow = 30 * time.Second
+
+// defaultBuildKillGracePeriodSeconds is the grace period given to the builder container
+// to exit on its own once a build's deadline has expired.
+const defaultBuildKillGracePeriodSeconds = 10
+
+// BuildPhaseName identifies a well-known stage of a build pod's execution.
+//
+// This only covers BuildPhasePending and BuildPhaseComplete, recorded directly by
+// BuildPodController from the build's own status transitions. The original request also
+// asked for PullingBuilderImage/Cloning/Building/Pushing, diffed from the build pod's
+// init-container statuses, and for the resulting timeline to be persisted on the build
+// via a new buildapi.Status.Phases field. Both require changes outside this package --
+// every BuildStrategy would need to name its init containers consistently, and
+// buildapi.Build would need the new field -- so that part is out of scope here and is
+// tracked as separate follow-up work rather than claimed as done.
+type BuildPhaseName string
+
+const (
+	BuildPhasePending  BuildPhaseName = "Pending"
+	BuildPhaseComplete BuildPhaseName = "Complete"
+)
+
+// BuildPhaseRecord captures when a build entered a given phase, how long it spent there,
+// and why it ended (if known). It is kept in BuildPodController's in-process
+// phaseHistory rather than on buildapi.Build -- see BuildPhaseName -- so it only backs
+// the buildPhase events emitted below, not anything visible through the API.
+type BuildPhaseRecord struct {
+	Name            BuildPhaseName
+	StartedAt       util.Time
+	DurationSeconds int64
+	Reason          string
 }
 
 // BuildPodController watches pods running builds and manages the build state
@@ -136,6 +578,69 @@ type BuildPodController struct {
 	BuildStore   cache.Store
 	BuildUpdater buildclient.BuildUpdater
 	PodManager   podManager
+	Recorder     record.EventRecorder
+
+	// BuildTimeout is the default wall-clock deadline applied to a running build when
+	// it has no Parameters.CompletionDeadlineSeconds of its own. Zero means no default.
+	BuildTimeout time.Duration
+
+	// phaseHistory tracks each build's phase timeline in-process, keyed by
+	// "namespace/name", guarded by phaseHistoryMu. See BuildPhaseRecord for why this
+	// cannot yet live on the build object itself.
+	phaseHistory   map[string][]BuildPhaseRecord
+	phaseHistoryMu sync.Mutex
+}
+
+// StartDeadlineSweep periodically checks every running build's deadline against
+// BuildStore, so a build is killed close to on time even if its pod stops producing
+// watch events (a hung builder) and HandlePod is not invoked again before the
+// informer's next relist. It returns immediately; close stopCh to stop the sweep.
+func (bc *BuildPodController) StartDeadlineSweep(period time.Duration, stopCh <-chan struct{}) {
+	if bc.BuildStore == nil {
+		return
+	}
+	go util.Until(bc.sweepExpiredBuilds, period, stopCh)
+}
+
+func (bc *BuildPodController) sweepExpiredBuilds() {
+	for _, obj := range bc.BuildStore.List() {
+		cached, ok := obj.(*buildapi.Build)
+		if !ok || cached.Status != buildapi.BuildStatusRunning {
+			continue
+		}
+		_, expired, ok := bc.checkBuildDeadline(cached)
+		if !ok || !expired {
+			continue
+		}
+
+		pod, err := bc.PodManager.GetPod(cached.Namespace, buildutil.GetBuildPodName(cached))
+		if err != nil {
+			glog.V(2).Infof("Failed to find pod for expired build %s/%s during deadline sweep: %v", cached.Namespace, cached.Name, err)
+			continue
+		}
+		if pod == nil {
+			continue
+		}
+		if isPodTerminal(pod) {
+			// The pod already reached its real outcome; let the normal watch-driven
+			// HandlePod path record that instead of killing a pod that's already done.
+			continue
+		}
+
+		// cached is a pointer straight out of BuildStore; HandlePod may be mutating the
+		// same object concurrently off the normal watch path, so work on a copy rather
+		// than risk both goroutines writing build.Status to one object and racing on
+		// BuildUpdater.Update, as createBuildPodWithRetries already does for pods.
+		copied, err := kapi.Scheme.Copy(cached)
+		if err != nil {
+			glog.V(2).Infof("Unable to copy expired build %s/%s before killing it during deadline sweep: %v", cached.Namespace, cached.Name, err)
+			continue
+		}
+		build := copied.(*buildapi.Build)
+		if err := bc.killExpiredBuild(build, pod); err != nil {
+			glog.V(2).Infof("Failed to kill expired build %s/%s during deadline sweep: %v", build.Namespace, build.Name, err)
+		}
+	}
 }
 
 func (bc *BuildPodController) HandlePod(pod *kapi.Pod) error {
@@ -162,6 +667,20 @@ func (bc *BuildPodController) HandlePod(pod *kapi.Pod) error {
 		return nil
 	}
 
+	// Skip the deadline check entirely once the pod has already reached a terminal
+	// phase: it is no longer possible to kill it, and the pod's real outcome below must
+	// win over a deadline that happened to expire in the same watch event.
+	if build.Status == buildapi.BuildStatusRunning && !isPodTerminal(pod) {
+		if remaining, expired, ok := bc.checkBuildDeadline(build); ok {
+			switch {
+			case expired:
+				return bc.killExpiredBuild(build, pod)
+			case remaining <= buildTimeoutWarningWindow:
+				bc.Recorder.Eventf(build, "aboutToTimeout", "Build %s will be terminated in %s if it does not complete", build.Name, remaining)
+			}
+		}
+	}
+
 	nextStatus := build.Status
 
 	switch pod.Status.Phase {
@@ -185,15 +704,114 @@ func (bc *BuildPodController) HandlePod(pod *kapi.Pod) error {
 		if buildutil.IsBuildComplete(build) {
 			now := util.Now()
 			build.CompletionTimestamp = &now
+			bc.recordPhase(build, BuildPhaseComplete, now, 0, string(build.Status))
+			bc.forgetPhaseHistory(build)
 		}
 		if build.Status == buildapi.BuildStatusRunning {
 			now := util.Now()
 			build.StartTimestamp = &now
+			bc.recordPhase(build, BuildPhasePending, build.CreationTimestamp, int64(now.Time.Sub(build.CreationTimestamp.Time).Seconds()), "")
 		}
 		if err := bc.BuildUpdater.Update(build.Namespace, build); err != nil {
 			return fmt.Errorf("Failed to update build %s: %v", build.Name, err)
 		}
+		return nil
 	}
+
+	return nil
+}
+
+// recordPhase appends or updates the BuildPhaseRecord for name in build's phase history,
+// and emits a typed event the first time the phase is observed so `oc describe build`
+// shows a timeline of the build's progress. It returns true if the history was actually
+// added to or changed.
+func (bc *BuildPodController) recordPhase(build *buildapi.Build, name BuildPhaseName, startedAt util.Time, durationSeconds int64, reason string) bool {
+	key := build.Namespace + "/" + build.Name
+
+	bc.phaseHistoryMu.Lock()
+	defer bc.phaseHistoryMu.Unlock()
+
+	if bc.phaseHistory == nil {
+		bc.phaseHistory = map[string][]BuildPhaseRecord{}
+	}
+	phases := bc.phaseHistory[key]
+	for i := range phases {
+		if phases[i].Name != name {
+			continue
+		}
+		if phases[i].DurationSeconds == durationSeconds && phases[i].Reason == reason {
+			return false
+		}
+		phases[i].DurationSeconds = durationSeconds
+		phases[i].Reason = reason
+		return true
+	}
+	bc.phaseHistory[key] = append(phases, BuildPhaseRecord{
+		Name:            name,
+		StartedAt:       startedAt,
+		DurationSeconds: durationSeconds,
+		Reason:          reason,
+	})
+	bc.Recorder.Eventf(build, "buildPhase", "Build %s entered phase %s", build.Name, name)
+	return true
+}
+
+// forgetPhaseHistory discards build's in-process phase history once it has completed, so
+// phaseHistory does not grow without bound across the controller's lifetime.
+func (bc *BuildPodController) forgetPhaseHistory(build *buildapi.Build) {
+	key := build.Namespace + "/" + build.Name
+	bc.phaseHistoryMu.Lock()
+	delete(bc.phaseHistory, key)
+	bc.phaseHistoryMu.Unlock()
+}
+
+// checkBuildDeadline reports how long remains before build's deadline expires and
+// whether it has already expired. ok is false if no deadline applies to build, either
+// because none was configured or the build has not recorded a start time yet.
+//
+// An explicit Parameters.CompletionDeadlineSeconds of 0 is, like ActiveDeadlineSeconds on
+// Jobs, a deadline of zero seconds rather than "no deadline" -- it expires the build
+// immediately. Only the absence of a default (bc.BuildTimeout <= 0) and no explicit
+// override means no deadline applies.
+func (bc *BuildPodController) checkBuildDeadline(build *buildapi.Build) (remaining time.Duration, expired bool, ok bool) {
+	if build.StartTimestamp == nil {
+		return 0, false, false
+	}
+
+	deadline := bc.BuildTimeout
+	explicit := build.Parameters.CompletionDeadlineSeconds != nil
+	if explicit {
+		deadline = time.Duration(*build.Parameters.CompletionDeadlineSeconds) * time.Second
+	}
+	if deadline <= 0 && !explicit {
+		return 0, false, false
+	}
+
+	remaining = deadline - time.Now().Sub(build.StartTimestamp.Time)
+	return remaining, remaining <= 0, true
+}
+
+// killExpiredBuild deletes the pod for a build that has exceeded its deadline and
+// transitions the build to BuildStatusFailed.
+func (bc *BuildPodController) killExpiredBuild(build *buildapi.Build, pod *kapi.Pod) error {
+	glog.V(2).Infof("Build %s exceeded its deadline, deleting pod %s/%s", build.Name, pod.Namespace, pod.Name)
+
+	options := kapi.NewDeleteOptions(defaultBuildKillGracePeriodSeconds)
+	if err := bc.PodManager.DeletePod(build.Namespace, pod, options); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to delete pod %s/%s for expired build %s: %v", pod.Namespace, pod.Name, build.Name, err)
+	}
+
+	build.Status = buildapi.BuildStatusFailed
+	build.Message = "Build exceeded deadline"
+	now := util.Now()
+	build.CompletionTimestamp = &now
+	bc.recordPhase(build, BuildPhaseComplete, now, 0, string(build.Status))
+	bc.forgetPhaseHistory(build)
+	if err := bc.BuildUpdater.Update(build.Namespace, build); err != nil {
+		return fmt.Errorf("Failed to update build %s: %v", build.Name, err)
+	}
+
+	bc.Recorder.Eventf(build, "timedOut", "Build %s was terminated because it exceeded its deadline", build.Name)
 	return nil
 }
 
@@ -204,7 +822,7 @@ func (bc *BuildPodController) CancelBuild(build *buildapi.Build, pod *kapi.Pod)
 		return nil
 	}
 
-	err := bc.PodManager.DeletePod(build.Namespace, pod)
+	err := bc.PodManager.DeletePod(build.Namespace, pod, nil)
 	if err != nil && !errors.IsNotFound(err) {
 		return err
 	}
@@ -225,6 +843,12 @@ func isBuildCancellable(build *buildapi.Build) bool {
 	return build.Status == buildapi.BuildStatusNew || build.Status == buildapi.BuildStatusPending || build.Status == buildapi.BuildStatusRunning
 }
 
+// isPodTerminal returns true if pod has reached a phase it will not leave on its own,
+// i.e. its containers have already stopped running.
+func isPodTerminal(pod *kapi.Pod) bool {
+	return pod.Status.Phase == kapi.PodSucceeded || pod.Status.Phase == kapi.PodFailed
+}
+
 // BuildPodDeleteController watches pods running builds and updates the build if the pod is deleted
 type BuildPodDeleteController struct {
 	BuildStore   cache.Store
@@ -268,9 +892,29 @@ func (bc *BuildPodDeleteController) HandleBuildPodDeletion(pod *kapi.Pod) error
 	return nil
 }
 
+// defaultBuildPodTerminationGracePeriod is how long a build pod is given to flush logs
+// and push partial layers before being force-killed, used when BuildDeleteController
+// does not set a PodTerminationGracePeriod of its own.
+const defaultBuildPodTerminationGracePeriod = 30 * time.Second
+
 // BuildDeleteController watches for builds being deleted and cleans up associated pods
 type BuildDeleteController struct {
+	BuildStore cache.Store
 	PodManager podManager
+
+	// PodTerminationGracePeriod is how long a build pod is given to flush logs and push
+	// partial layers before being force-killed. Zero means
+	// defaultBuildPodTerminationGracePeriod is used.
+	PodTerminationGracePeriod time.Duration
+
+	// BuildStoreSynced reports whether BuildStore's informer has completed its initial
+	// List, so "build not found in BuildStore" can safely be read as "build no longer
+	// exists" rather than "not observed yet". StartOrphanSweep will not delete any pod
+	// until this returns true, so a cold BuildStore on controller startup does not look
+	// like every running build was deleted. This is required: if left nil, the sweep
+	// fails closed and never deletes anything, rather than risk mass-deleting every
+	// running build's pod because nobody wired up the check.
+	BuildStoreSynced func() bool
 }
 
 func (bc *BuildDeleteController) HandleBuildDeletion(build *buildapi.Build) error {
@@ -289,10 +933,67 @@ func (bc *BuildDeleteController) HandleBuildDeletion(build *buildapi.Build) erro
 		glog.V(2).Infof("Not deleting pod %s/%s because the build label %s does not match the build name %s", pod.Namespace, podName, pod.Labels[buildapi.BuildLabel], build.Name)
 		return nil
 	}
-	err = bc.PodManager.DeletePod(build.Namespace, pod)
+	err = bc.PodManager.DeletePod(build.Namespace, pod, kapi.NewDeleteOptions(bc.gracePeriodSeconds()))
 	if err != nil {
 		glog.V(2).Infof("Failed to delete pod %s/%s for build %s due to error: %v", build.Namespace, podName, build.Name, err)
 		return err
 	}
 	return nil
 }
+
+// gracePeriodSeconds returns bc.PodTerminationGracePeriod in seconds, or
+// defaultBuildPodTerminationGracePeriod if it is unset.
+func (bc *BuildDeleteController) gracePeriodSeconds() int64 {
+	if bc.PodTerminationGracePeriod <= 0 {
+		return int64(defaultBuildPodTerminationGracePeriod / time.Second)
+	}
+	return int64(bc.PodTerminationGracePeriod / time.Second)
+}
+
+// StartOrphanSweep starts a goroutine that periodically deletes build pods whose
+// referenced Build no longer exists in BuildStore. This closes the gap where an
+// apiserver hiccup during build deletion would otherwise leak the pod indefinitely. Each
+// tick is skipped unless BuildStoreSynced is set and confirms BuildStore's initial sync
+// has completed, so the sweep never mistakes a cold or unconfigured cache for mass build
+// deletion. It returns immediately; close stopCh to stop the sweep.
+func (bc *BuildDeleteController) StartOrphanSweep(period time.Duration, stopCh <-chan struct{}) {
+	go util.Until(bc.sweepOrphanedPods, period, stopCh)
+}
+
+func (bc *BuildDeleteController) sweepOrphanedPods() {
+	if bc.BuildStoreSynced == nil {
+		glog.V(2).Infof("Skipping orphan build pod sweep: BuildStoreSynced is not configured")
+		return
+	}
+	if !bc.BuildStoreSynced() {
+		glog.V(4).Infof("Skipping orphan build pod sweep: build store has not completed its initial sync")
+		return
+	}
+
+	pods, err := bc.PodManager.ListPods(kapi.NamespaceAll, labels.Everything())
+	if err != nil {
+		glog.V(2).Infof("Failed to list pods for orphan build pod sweep: %v", err)
+		return
+	}
+
+	options := kapi.NewDeleteOptions(bc.gracePeriodSeconds())
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		buildName, hasBuildLabel := pod.Labels[buildapi.BuildLabel]
+		if !hasBuildLabel {
+			continue
+		}
+		// Unlike the deadline and queue sweeps, this only checks for the Build's
+		// presence in BuildStore and never reads or mutates the returned object itself,
+		// so there is no cached *buildapi.Build pointer here that could race with the
+		// normal watch path.
+		if _, exists, err := bc.BuildStore.GetByKey(pod.Namespace + "/" + buildName); err == nil && exists {
+			continue
+		}
+
+		glog.V(2).Infof("Deleting orphaned build pod %s/%s: build %s no longer exists", pod.Namespace, pod.Name, buildName)
+		if err := bc.PodManager.DeletePod(pod.Namespace, pod, options); err != nil && !errors.IsNotFound(err) {
+			glog.V(2).Infof("Failed to delete orphaned build pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+}
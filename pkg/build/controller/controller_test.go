@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	errors "github.com/GoogleCloudPlatform/kubernetes/pkg/api/errors"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+)
+
+type fakeEventRecorder struct{}
+
+func (fakeEventRecorder) Event(object runtime.Object, reason, message string) {}
+func (fakeEventRecorder) Eventf(object runtime.Object, reason, messageFmt string, args ...interface{}) {
+}
+func (fakeEventRecorder) PastEventf(object runtime.Object, timestamp util.Time, reason, messageFmt string, args ...interface{}) {
+}
+
+func newBuild(namespace, name, buildConfigName string, status buildapi.BuildStatus) *buildapi.Build {
+	labels := map[string]string{}
+	if len(buildConfigName) > 0 {
+		labels[buildapi.BuildConfigLabel] = buildConfigName
+	}
+	return &buildapi.Build{
+		ObjectMeta: kapi.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Status: status,
+	}
+}
+
+// TestDefaultBuildQueueAdmitScopesByNamespace verifies that two BuildConfigs that share a
+// name in different namespaces are tracked independently.
+func TestDefaultBuildQueueAdmitScopesByNamespace(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(newBuild("b", "running-in-b", "shared", buildapi.BuildStatusRunning))
+
+	q := &DefaultBuildQueue{
+		BuildStore: store,
+		Policy: func(namespace, buildConfigName string) (BuildConcurrencyPolicy, int) {
+			return BuildConcurrencyPolicySerial, 0
+		},
+	}
+
+	candidateInA := newBuild("a", "new-in-a", "shared", buildapi.BuildStatusNew)
+	admitted, err := q.Admit(candidateInA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !admitted {
+		t.Fatalf("expected build in namespace a to be admitted; a running build in namespace b must not block it")
+	}
+
+	store.Add(newBuild("a", "running-in-a", "shared", buildapi.BuildStatusRunning))
+	admitted, err = q.Admit(candidateInA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if admitted {
+		t.Fatalf("expected build in namespace a to be blocked by the already-running build in its own namespace")
+	}
+}
+
+type retryFixture struct {
+	attempts int
+	deleted  bool
+}
+
+func (f *retryFixture) CreateBuildPod(build *buildapi.Build) (*kapi.Pod, error) {
+	return &kapi.Pod{ObjectMeta: kapi.ObjectMeta{Namespace: build.Namespace, Name: build.Name}}, nil
+}
+
+func (f *retryFixture) CreatePod(namespace string, pod *kapi.Pod) (*kapi.Pod, error) {
+	f.attempts++
+	if f.attempts == 1 {
+		return nil, errors.NewConflict("pods", pod.Name, fmt.Errorf("simulated conflict"))
+	}
+	return pod, nil
+}
+func (f *retryFixture) DeletePod(namespace string, pod *kapi.Pod, options *kapi.DeleteOptions) error {
+	f.deleted = true
+	return nil
+}
+func (f *retryFixture) GetPod(namespace, name string) (*kapi.Pod, error) { return nil, nil }
+func (f *retryFixture) ListPods(namespace string, selector labels.Selector) (*kapi.PodList, error) {
+	return &kapi.PodList{}, nil
+}
+
+type refreshingBuildGetter struct {
+	refreshed *buildapi.Build
+}
+
+func (g *refreshingBuildGetter) Get(namespace, name string) (*buildapi.Build, error) {
+	return g.refreshed, nil
+}
+
+// TestCreateBuildPodWithRetriesPersistsRefreshedBuild verifies that once a retry
+// succeeds after re-fetching the build, nextBuildStatus returns that re-fetched build,
+// not the original, possibly stale, one the caller started with.
+func TestCreateBuildPodWithRetriesPersistsRefreshedBuild(t *testing.T) {
+	original := newBuild("ns", "build-1", "bc", buildapi.BuildStatusNew)
+	refreshed := newBuild("ns", "build-1", "bc", buildapi.BuildStatusNew)
+	refreshed.Message = "refreshed-after-retry"
+
+	bc := &BuildController{
+		BuildStrategy: &retryFixture{},
+		PodManager:    &retryFixture{},
+		BuildGetter:   &refreshingBuildGetter{refreshed: refreshed},
+		Recorder:      fakeEventRecorder{},
+		BuildPodCreationRetryParams: BuildPodCreationRetryParams{
+			Steps:    2,
+			Interval: time.Millisecond,
+			Factor:   1,
+			Jitter:   0,
+		},
+	}
+
+	current, err := bc.nextBuildStatus(original)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current != refreshed {
+		t.Fatalf("expected nextBuildStatus to return the build re-fetched mid-retry, got a different object (Message=%q)", current.Message)
+	}
+	if current.Status != buildapi.BuildStatusPending {
+		t.Fatalf("expected the persisted build to be marked pending, got %s", current.Status)
+	}
+}
+
+type fakeBuildUpdater struct {
+	updates []*buildapi.Build
+}
+
+func (f *fakeBuildUpdater) Update(namespace string, build *buildapi.Build) error {
+	f.updates = append(f.updates, build)
+	return nil
+}
+
+// TestHandlePodDoesNotKillABuildWhosePodAlreadySucceeded verifies that a build pod which
+// reaches PodSucceeded at or after its CompletionDeadlineSeconds is recorded as a normal
+// completion, not killed and marked failed by the deadline check.
+func TestHandlePodDoesNotKillABuildWhosePodAlreadySucceeded(t *testing.T) {
+	build := newBuild("ns", "build-1", "bc", buildapi.BuildStatusRunning)
+	started := util.Time{Time: time.Now().Add(-time.Hour)}
+	build.StartTimestamp = &started
+	deadline := int64(1)
+	build.Parameters.CompletionDeadlineSeconds = &deadline
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(build)
+
+	updater := &fakeBuildUpdater{}
+	podManager := &retryFixture{}
+	bc := &BuildPodController{
+		BuildStore:   store,
+		BuildUpdater: updater,
+		PodManager:   podManager,
+		Recorder:     fakeEventRecorder{},
+	}
+
+	pod := &kapi.Pod{
+		ObjectMeta: kapi.ObjectMeta{Namespace: "ns", Name: "build-1"},
+		Status:     kapi.PodStatus{Phase: kapi.PodSucceeded},
+	}
+
+	if err := bc.HandlePod(pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if podManager.deleted {
+		t.Fatalf("expected the already-succeeded pod not to be deleted by the deadline check")
+	}
+	if len(updater.updates) != 1 {
+		t.Fatalf("expected exactly one build update, got %d", len(updater.updates))
+	}
+	if updater.updates[0].Status != buildapi.BuildStatusComplete {
+		t.Fatalf("expected build to be marked complete from its pod's actual outcome, got %s", updater.updates[0].Status)
+	}
+}
+
+// TestCheckBuildDeadline covers the configurations checkBuildDeadline needs to tell apart:
+// no deadline at all, an explicit deadline of zero (expires immediately, unlike leaving
+// CompletionDeadlineSeconds unset), an explicit deadline already in the past, and one
+// still in the future.
+func TestCheckBuildDeadline(t *testing.T) {
+	tests := []struct {
+		name            string
+		buildTimeout    time.Duration
+		deadlineSeconds *int64
+		startedAgo      time.Duration
+		wantOK          bool
+		wantExpired     bool
+	}{
+		{
+			name:         "no default timeout and no override configured",
+			buildTimeout: 0,
+			startedAgo:   time.Hour,
+			wantOK:       false,
+		},
+		{
+			name:            "explicit zero deadline expires immediately",
+			buildTimeout:    0,
+			deadlineSeconds: int64Ptr(0),
+			startedAgo:      time.Millisecond,
+			wantOK:          true,
+			wantExpired:     true,
+		},
+		{
+			name:            "explicit negative deadline is already expired",
+			buildTimeout:    0,
+			deadlineSeconds: int64Ptr(-1),
+			startedAgo:      time.Millisecond,
+			wantOK:          true,
+			wantExpired:     true,
+		},
+		{
+			name:            "explicit deadline still in the future",
+			buildTimeout:    0,
+			deadlineSeconds: int64Ptr(3600),
+			startedAgo:      time.Minute,
+			wantOK:          true,
+			wantExpired:     false,
+		},
+		{
+			name:         "default timeout exceeded",
+			buildTimeout: time.Second,
+			startedAgo:   time.Hour,
+			wantOK:       true,
+			wantExpired:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			build := newBuild("ns", "build-1", "bc", buildapi.BuildStatusRunning)
+			started := util.Time{Time: time.Now().Add(-test.startedAgo)}
+			build.StartTimestamp = &started
+			build.Parameters.CompletionDeadlineSeconds = test.deadlineSeconds
+
+			bc := &BuildPodController{BuildTimeout: test.buildTimeout}
+			_, expired, ok := bc.checkBuildDeadline(build)
+			if ok != test.wantOK {
+				t.Fatalf("expected ok=%v, got %v", test.wantOK, ok)
+			}
+			if ok && expired != test.wantExpired {
+				t.Fatalf("expected expired=%v, got %v", test.wantExpired, expired)
+			}
+		})
+	}
+}
+
+// TestCheckBuildDeadlineNoStartTimestamp verifies that a build with no recorded start
+// time never has a deadline applied to it, regardless of how it is configured.
+func TestCheckBuildDeadlineNoStartTimestamp(t *testing.T) {
+	build := newBuild("ns", "build-1", "bc", buildapi.BuildStatusRunning)
+	deadline := int64(1)
+	build.Parameters.CompletionDeadlineSeconds = &deadline
+
+	bc := &BuildPodController{BuildTimeout: time.Hour}
+	_, _, ok := bc.checkBuildDeadline(build)
+	if ok {
+		t.Fatalf("expected no deadline to apply to a build with no StartTimestamp")
+	}
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+// fakePodLister backs the orphan sweep's ListPods/DeletePod calls with an in-memory pod
+// list and records which pods were deleted.
+type fakePodLister struct {
+	pods    []kapi.Pod
+	deleted []string
+}
+
+func (f *fakePodLister) CreatePod(namespace string, pod *kapi.Pod) (*kapi.Pod, error) {
+	return pod, nil
+}
+func (f *fakePodLister) DeletePod(namespace string, pod *kapi.Pod, options *kapi.DeleteOptions) error {
+	f.deleted = append(f.deleted, namespace+"/"+pod.Name)
+	return nil
+}
+func (f *fakePodLister) GetPod(namespace, name string) (*kapi.Pod, error) { return nil, nil }
+func (f *fakePodLister) ListPods(namespace string, selector labels.Selector) (*kapi.PodList, error) {
+	return &kapi.PodList{Items: f.pods}, nil
+}
+
+// orphanedBuildPod returns a pod labelled as belonging to a build that does not exist in
+// any BuildStore used by these tests.
+func orphanedBuildPod(namespace, name, buildName string) kapi.Pod {
+	return kapi.Pod{
+		ObjectMeta: kapi.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{buildapi.BuildLabel: buildName},
+		},
+	}
+}
+
+// TestSweepOrphanedPodsRequiresBuildStoreSynced verifies that the orphan sweep deletes
+// nothing, even when every pod it sees is clearly orphaned, until BuildStoreSynced is
+// both configured and returns true -- the fail-closed behavior that keeps a cold
+// BuildStore from looking like mass build deletion.
+func TestSweepOrphanedPodsRequiresBuildStoreSynced(t *testing.T) {
+	pods := &fakePodLister{pods: []kapi.Pod{orphanedBuildPod("ns", "orphan-1", "gone")}}
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	// BuildStoreSynced not configured at all.
+	bc := &BuildDeleteController{BuildStore: store, PodManager: pods}
+	bc.sweepOrphanedPods()
+	if len(pods.deleted) != 0 {
+		t.Fatalf("expected no deletions with BuildStoreSynced unset, got %v", pods.deleted)
+	}
+
+	// BuildStoreSynced configured but not yet synced.
+	bc.BuildStoreSynced = func() bool { return false }
+	bc.sweepOrphanedPods()
+	if len(pods.deleted) != 0 {
+		t.Fatalf("expected no deletions before BuildStoreSynced reports true, got %v", pods.deleted)
+	}
+}
+
+// TestSweepOrphanedPodsDeletesPodsOnceSynced verifies that once BuildStoreSynced reports
+// true, the sweep deletes pods whose build label names a build absent from BuildStore,
+// and leaves alone pods whose build is still present.
+func TestSweepOrphanedPodsDeletesPodsOnceSynced(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(newBuild("ns", "still-here", "bc", buildapi.BuildStatusRunning))
+
+	pods := &fakePodLister{pods: []kapi.Pod{
+		orphanedBuildPod("ns", "orphan-1", "gone"),
+		orphanedBuildPod("ns", "keep-1", "still-here"),
+	}}
+
+	bc := &BuildDeleteController{
+		BuildStore:       store,
+		PodManager:       pods,
+		BuildStoreSynced: func() bool { return true },
+	}
+	bc.sweepOrphanedPods()
+
+	if len(pods.deleted) != 1 || pods.deleted[0] != "ns/orphan-1" {
+		t.Fatalf("expected only the orphaned pod to be deleted, got %v", pods.deleted)
+	}
+}